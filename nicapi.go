@@ -8,9 +8,14 @@
 package nicapi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -19,14 +24,110 @@ import (
 
 	"github.com/jetstack/cert-manager/pkg/issuer/acme/dns/util"
 	pkgutil "github.com/jetstack/cert-manager/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 )
 
 const APIURL = "https://connect.nicapi.eu/api/v1"
 
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	defaultPollingInterval    = 2 * time.Second
+	defaultHTTPTimeout        = 30 * time.Second
+	defaultMaxRetries         = 3
+	defaultBackoffBase        = 1 * time.Second
+)
+
+// Config configures a DNSProvider. Use NewDefaultConfig to obtain a Config
+// pre-populated with the provider's default values, then override only the
+// fields that need to change.
+type Config struct {
+	AuthKey          string
+	DNS01Nameservers []string
+
+	// APIURL is the base URL of the NicAPI. Overriding it lets tests
+	// point the provider at a fake httptest.Server.
+	APIURL string
+
+	// PropagationTimeout bounds how long Present waits for the TXT record
+	// to become visible on every authoritative nameserver of the zone
+	// before giving up.
+	PropagationTimeout time.Duration
+	// PollingInterval is the delay between propagation checks.
+	PollingInterval time.Duration
+	// DisablePropagationCheck skips the propagation check entirely,
+	// restoring the old fire-and-forget behaviour of Present.
+	DisablePropagationCheck bool
+
+	// FollowCNAME makes the provider resolve a CNAME at the challenge FQDN
+	// and operate on its target instead, so the zone holding the ACME
+	// challenge records can be delegated away from the production zone.
+	FollowCNAME bool
+
+	// Dump enables verbose logging of request bodies at V(4), for
+	// debugging only: those bodies contain the domains and challenge
+	// values being solved. Response bodies are not logged.
+	Dump bool
+
+	// HTTPClient is the client used to talk to the NicAPI. Overriding it
+	// lets tests point the provider at an httptest.Server.
+	HTTPClient *http.Client
+	// MaxRetries bounds how many times a request is retried after a
+	// transient failure: connection errors, HTTP 429, and HTTP 5xx.
+	MaxRetries int
+	// BackoffBase is the base delay of the exponential backoff applied
+	// between retries, absent a Retry-After header on the response.
+	BackoffBase time.Duration
+
+	// FindZoneByFqdn resolves the authoritative zone for fqdn. It defaults
+	// to util.FindZoneByFqdn; tests override it so zone lookup doesn't
+	// depend on real DNS.
+	FindZoneByFqdn func(fqdn string, nameservers []string) (string, error)
+
+	// LookupCNAME resolves the CNAME target of name against server, a
+	// "host:port" nameserver, or "" for the system resolver. It returns ""
+	// if name is not a CNAME. It defaults to querying server directly over
+	// the network; tests override it so FollowCNAME doesn't depend on real
+	// DNS.
+	LookupCNAME func(server, name string) (string, error)
+
+	// LookupTXT returns the TXT records at name from server, a "host:port"
+	// nameserver. It defaults to querying server directly over the network;
+	// tests override it so the propagation check doesn't depend on real
+	// DNS.
+	LookupTXT func(server, name string) ([]string, error)
+
+	// LookupNS resolves the authoritative nameservers of zone, returned as
+	// "host:port" addresses ready to pass to LookupTXT. It defaults to the
+	// system resolver's NS records; tests override it so the propagation
+	// check's nameserver discovery doesn't depend on real DNS.
+	LookupNS func(zone string) ([]string, error)
+}
+
+// NewDefaultConfig returns a Config populated with default values.
+func NewDefaultConfig() *Config {
+	return &Config{
+		APIURL:             APIURL,
+		PropagationTimeout: defaultPropagationTimeout,
+		PollingInterval:    defaultPollingInterval,
+		HTTPClient:         &http.Client{Timeout: defaultHTTPTimeout},
+		MaxRetries:         defaultMaxRetries,
+		BackoffBase:        defaultBackoffBase,
+		FindZoneByFqdn:     util.FindZoneByFqdn,
+		LookupCNAME:        lookupCNAMEOverNetwork,
+		LookupTXT:          lookupTXTOverNetwork,
+		LookupNS:           lookupNSOverNetwork,
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 type DNSProvider struct {
 	dns01Nameservers []string
 	authKey          string
+	config           *Config
+	client           *client
 }
 
 // NewDNSProvider returns a DNSProvider instance.
@@ -39,25 +140,102 @@ func NewDNSProvider(dns01Nameservers []string) (*DNSProvider, error) {
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance.
 func NewDNSProviderCredentials(key string, dns01Nameservers []string) (*DNSProvider, error) {
-	if key == "" {
+	config := NewDefaultConfig()
+	config.AuthKey = key
+	config.DNS01Nameservers = dns01Nameservers
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderSecretRef resolves the API key from the given secretRef via
+// k8sClient and returns a DNSProvider instance, mirroring cert-manager's
+// standard Issuer.spec.acme.solvers[].dns01.webhook.config secret-reference
+// pattern rather than requiring the credential in an env var.
+func NewDNSProviderSecretRef(k8sClient kubernetes.Interface, namespace string, secretRef corev1.SecretKeySelector, dns01Nameservers []string) (*DNSProvider, error) {
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(context.Background(), secretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("nicapi: failed to load secret %s/%s: %v", namespace, secretRef.Name, err)
+	}
+
+	key, ok := secret.Data[secretRef.Key]
+	if !ok {
+		return nil, fmt.Errorf("nicapi: key %q not found in secret %s/%s", secretRef.Key, namespace, secretRef.Name)
+	}
+
+	return NewDNSProviderCredentials(string(key), dns01Nameservers)
+}
+
+// NewDNSProviderConfig returns a DNSProvider instance configured with the
+// supplied Config.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, fmt.Errorf("nicapi: the configuration of the DNS provider is nil")
+	}
+
+	if config.AuthKey == "" {
 		return nil, fmt.Errorf("credentials missing")
 	}
 
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	if config.APIURL == "" {
+		config.APIURL = APIURL
+	}
+
+	if config.PropagationTimeout == 0 {
+		config.PropagationTimeout = defaultPropagationTimeout
+	}
+
+	if config.PollingInterval == 0 {
+		config.PollingInterval = defaultPollingInterval
+	}
+
+	// MaxRetries and BackoffBase are deliberately not defaulted here the
+	// way PropagationTimeout and PollingInterval are above: 0 is a valid,
+	// working value for both (no retries; no backoff delay between them),
+	// used by tests to run offline against an httptest.Server without
+	// waiting out a real backoff. Only NewDefaultConfig sets them.
+
+	if config.FindZoneByFqdn == nil {
+		config.FindZoneByFqdn = util.FindZoneByFqdn
+	}
+
+	if config.LookupCNAME == nil {
+		config.LookupCNAME = lookupCNAMEOverNetwork
+	}
+
+	if config.LookupTXT == nil {
+		config.LookupTXT = lookupTXTOverNetwork
+	}
+
+	if config.LookupNS == nil {
+		config.LookupNS = lookupNSOverNetwork
+	}
+
 	return &DNSProvider{
-		authKey:          key,
-		dns01Nameservers: dns01Nameservers,
+		authKey:          config.AuthKey,
+		dns01Nameservers: config.DNS01Nameservers,
+		config:           config,
+		client:           newClient(config),
 	}, nil
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge
 func (c *DNSProvider) Present(domain, fqdn, value string) error {
 
+	fqdn, err := c.resolveFQDN(fqdn)
+	if err != nil {
+		return err
+	}
+
 	zone, err := c.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
 
-	record, err := c.findTxtRecord(zone, fqdn)
+	records, err := c.findTxtRecords(zone, fqdn)
 	if err != nil && err != errNoExistingRecord {
 		// this is a real error
 		return err
@@ -65,30 +243,12 @@ func (c *DNSProvider) Present(domain, fqdn, value string) error {
 
 	recordName := strings.TrimSuffix(util.UnFqdn(fqdn), fmt.Sprintf(".%s", zone.Zone.Name))
 
-	if record != nil {
+	for _, record := range records {
 		if record.Data == value {
-			// the record is already set to the desired value
+			// this exact (name, type, data) triple is already present, e.g.
+			// a wildcard and its apex sharing the same FQDN and value
 			return nil
 		}
-
-		record := dnsPostRecord{
-			Zone: zone.Zone.Name,
-			Records: []dnsRecord{
-				{
-					Name: recordName,
-				},
-			},
-		}
-
-		body, err := json.Marshal(record)
-		if err != nil {
-			return err
-		}
-
-		_, err = c.makeRequest("DELETE", "/dns/zones/records/delete", body)
-		if err != nil {
-			return err
-		}
 	}
 
 	recCol := dnsPostRecord{
@@ -114,30 +274,55 @@ func (c *DNSProvider) Present(domain, fqdn, value string) error {
 		return err
 	}
 
-	return nil
+	klog.V(2).Infof("nicapi: added TXT record for %s", fqdn)
+
+	if c.config.DisablePropagationCheck {
+		return nil
+	}
+
+	return c.waitForPropagation(fqdn, value)
 }
 
 // CleanUp removes the TXT record matching the specified parameters
 func (c *DNSProvider) CleanUp(domain, fqdn, value string) error {
+	fqdn, err := c.resolveFQDN(fqdn)
+	if err != nil {
+		return err
+	}
+
 	zone, err := c.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.findTxtRecord(zone, fqdn)
-	// Nothing to cleanup
+	records, err := c.findTxtRecords(zone, fqdn)
 	if err == errNoExistingRecord {
+		// Nothing to cleanup
 		return nil
 	}
 	if err != nil {
 		return err
 	}
 
+	var target *dnsRecord
+	for _, rec := range records {
+		if rec.Data == value {
+			target = &rec
+			break
+		}
+	}
+	if target == nil {
+		// the value being cleaned up is not (or no longer) present, leave
+		// any other TXT values at this name untouched
+		return nil
+	}
+
 	record := dnsPostRecord{
 		Zone: zone.Zone.Name,
 		Records: []dnsRecord{
 			{
-				Name: strings.TrimSuffix(util.UnFqdn(fqdn), fmt.Sprintf(".%s", zone.Zone.Name)),
+				ID:   target.ID,
+				Name: target.Name,
 			},
 		},
 	}
@@ -152,12 +337,90 @@ func (c *DNSProvider) CleanUp(domain, fqdn, value string) error {
 		return err
 	}
 
+	klog.V(2).Infof("nicapi: removed TXT record for %s", fqdn)
+
 	return nil
 }
 
+// resolveFQDN follows a CNAME at fqdn to its target when FollowCNAME is
+// enabled, so that a challenge FQDN CNAMEd out to a delegated zone is
+// looked up and written to that zone instead of the one the record
+// visibly lives under.
+func (c *DNSProvider) resolveFQDN(fqdn string) (string, error) {
+	if !c.config.FollowCNAME {
+		return fqdn, nil
+	}
+
+	cname, err := c.lookupCNAME(fqdn)
+	if err != nil {
+		return "", err
+	}
+
+	if cname == "" {
+		return fqdn, nil
+	}
+
+	return cname, nil
+}
+
+// lookupCNAME returns the canonical name for fqdn, or "" if fqdn is not a
+// CNAME. It queries the recursive nameservers configured for dns-01
+// self-checks rather than the system resolver, falling back to the system
+// resolver when none are configured - dns01Nameservers being empty is a
+// legitimate configuration meaning "use whatever the host resolves with".
+func (c *DNSProvider) lookupCNAME(fqdn string) (string, error) {
+	name := util.UnFqdn(fqdn)
+
+	servers := c.dns01Nameservers
+	if len(servers) == 0 {
+		servers = []string{""}
+	}
+
+	for _, server := range servers {
+		cname, err := c.config.LookupCNAME(server, name)
+		if err != nil {
+			continue
+		}
+
+		if cname == "" {
+			// no CNAME present
+			return "", nil
+		}
+
+		return util.ToFqdn(cname), nil
+	}
+
+	return "", fmt.Errorf("nicapi: could not resolve CNAME for %s", fqdn)
+}
+
+// lookupCNAMEOverNetwork is the default Config.LookupCNAME: it queries
+// server, or the system resolver if server is "", returning "" if name is
+// not a CNAME.
+func lookupCNAMEOverNetwork(server, name string) (string, error) {
+	resolver := &net.Resolver{PreferGo: true}
+	if server != "" {
+		resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 10 * time.Second}
+			return d.DialContext(ctx, network, server)
+		}
+	}
+
+	cname, err := resolver.LookupCNAME(context.Background(), name)
+	if err != nil {
+		return "", err
+	}
+
+	if util.UnFqdn(cname) == name {
+		// no CNAME present; LookupCNAME returns the queried name itself
+		return "", nil
+	}
+
+	return cname, nil
+}
+
 func (c *DNSProvider) getHostedZone(fqdn string) (*dnsZone, error) {
 
-	authZone, err := util.FindZoneByFqdn(fqdn, c.dns01Nameservers)
+	authZone, err := c.config.FindZoneByFqdn(fqdn, c.dns01Nameservers)
 	if err != nil {
 		return nil, err
 	}
@@ -189,17 +452,132 @@ func (c *DNSProvider) getHostedZone(fqdn string) (*dnsZone, error) {
 
 var errNoExistingRecord = errors.New("no existing record found")
 
-func (c *DNSProvider) findTxtRecord(zone *dnsZone, fqdn string) (*dnsRecord, error) {
+// findTxtRecords returns every TXT record at fqdn, since cert-manager may
+// solve a wildcard and its apex concurrently and both share the same name
+// while carrying different values.
+func (c *DNSProvider) findTxtRecords(zone *dnsZone, fqdn string) ([]dnsRecord, error) {
 
 	name := strings.TrimSuffix(util.UnFqdn(fqdn), fmt.Sprintf(".%s", zone.Zone.Name))
 
+	var records []dnsRecord
 	for _, rec := range zone.Zone.Records {
-		if rec.Name == name {
-			return &rec, nil
+		if rec.Name == name && rec.Type == "TXT" {
+			records = append(records, rec)
 		}
 	}
 
-	return nil, errNoExistingRecord
+	if len(records) == 0 {
+		return nil, errNoExistingRecord
+	}
+
+	return records, nil
+}
+
+// waitForPropagation blocks until the TXT record at fqdn is visible on every
+// authoritative nameserver of its zone, or PropagationTimeout elapses.
+// NicAPI's authoritative servers are eventually consistent, so returning
+// from Present before this check lets cert-manager ask Let's Encrypt to
+// validate a record that isn't resolvable yet, wasting a validation attempt.
+func (c *DNSProvider) waitForPropagation(fqdn, value string) error {
+	authZone, err := c.config.FindZoneByFqdn(fqdn, c.dns01Nameservers)
+	if err != nil {
+		return err
+	}
+
+	servers, err := c.config.LookupNS(util.UnFqdn(authZone))
+	if err != nil || len(servers) == 0 {
+		// fall back to the recursive resolvers already used for zone
+		// discovery if the authoritative servers can't be enumerated
+		return c.pollServers(c.dns01Nameservers, fqdn, value)
+	}
+
+	return c.pollServers(servers, fqdn, value)
+}
+
+// lookupNSOverNetwork is the default Config.LookupNS: it queries the system
+// resolver for zone's NS records.
+func lookupNSOverNetwork(zone string) ([]string, error) {
+	nameservers, err := net.LookupNS(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]string, len(nameservers))
+	for i, ns := range nameservers {
+		servers[i] = net.JoinHostPort(util.UnFqdn(ns.Host), "53")
+	}
+
+	return servers, nil
+}
+
+// pollServers polls every server once per round, sleeping PollingInterval
+// between rounds, until all of them have the expected TXT value or the
+// provider's PropagationTimeout elapses. Servers that already matched are
+// dropped from later rounds. Polling round-robin like this (rather than
+// exhausting one server's timeout before trying the next) matters because a
+// single slow-to-update nameserver would otherwise burn the whole deadline
+// on its own before the others - which may already be propagated - are ever
+// queried.
+func (c *DNSProvider) pollServers(servers []string, fqdn, value string) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("nicapi: no nameservers to check propagation of %s against", util.UnFqdn(fqdn))
+	}
+
+	fqdn = util.UnFqdn(fqdn)
+	deadline := time.Now().Add(c.config.PropagationTimeout)
+	pending := servers
+
+	for {
+		var remaining []string
+		for _, server := range pending {
+			ok, err := c.serverHasRecord(server, fqdn, value)
+			if err != nil || !ok {
+				remaining = append(remaining, server)
+			}
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("nicapi: timed out waiting for %s to propagate to %s", fqdn, strings.Join(remaining, ", "))
+		}
+
+		pending = remaining
+		time.Sleep(c.config.PollingInterval)
+	}
+}
+
+// serverHasRecord reports whether server already answers fqdn's TXT query
+// with value.
+func (c *DNSProvider) serverHasRecord(server, fqdn, value string) (bool, error) {
+	txts, err := c.config.LookupTXT(server, fqdn)
+	if err != nil {
+		return false, err
+	}
+
+	for _, txt := range txts {
+		if txt == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// lookupTXTOverNetwork is the default Config.LookupTXT: it queries server
+// directly for name's TXT records.
+func lookupTXTOverNetwork(server, name string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+
+	return resolver.LookupTXT(context.Background(), name)
 }
 
 func (c *DNSProvider) makeRequest(method, uri string, body []byte) (json.RawMessage, error) {
@@ -225,26 +603,23 @@ func (c *DNSProvider) makeRequest(method, uri string, body []byte) (json.RawMess
 		Data   json.RawMessage `json:"data"`
 	}
 
-	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", APIURL, uri), strings.NewReader(string(body)))
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", c.config.APIURL, uri), strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
 
-	q := req.URL.Query()
-	q.Add("authToken", c.authKey)
-	req.URL.RawQuery = q.Encode()
-
+	req.Header.Set("Authorization", "Bearer "+c.authKey)
 	req.Header.Set("User-Agent", pkgutil.CertManagerUserAgent)
 	req.Header.Set("Content-Type", "application/json")
 
-	fmt.Printf("HTTP Request: %s %s\n", method, fmt.Sprintf("%s%s", APIURL, uri))
-	fmt.Printf("HTTP Body: %s\n", string(body))
-
-	client := http.Client{
-		Timeout: 30 * time.Second,
+	klog.V(4).Infof("nicapi: HTTP request: %s %s", method, req.URL.String())
+	if c.config.Dump {
+		klog.V(4).Infof("nicapi: HTTP body: %s", string(body))
 	}
-	resp, err := client.Do(req)
+
+	resp, err := c.client.Do(req)
 	if err != nil {
+		klog.Errorf("nicapi: error querying API -> %v", err)
 		return nil, fmt.Errorf("error querying API -> %v", err)
 	}
 
@@ -256,7 +631,7 @@ func (c *DNSProvider) makeRequest(method, uri string, body []byte) (json.RawMess
 		return nil, err
 	}
 
-	fmt.Printf("HTTP Transaction: %s\n", r.MetaData.ServerTransactionId)
+	klog.V(4).Infof("nicapi: HTTP transaction: %s", r.MetaData.ServerTransactionId)
 
 	if r.Status != "success" {
 		if len(r.Messages.Errors) > 0 {
@@ -264,14 +639,108 @@ func (c *DNSProvider) makeRequest(method, uri string, body []byte) (json.RawMess
 			for _, apiErr := range r.Messages.Errors {
 				errStr += fmt.Sprintf("\t Error: %d: %s", apiErr.Code, apiErr.Message)
 			}
+			klog.Warningf("nicapi: API error for %s %s: %s", method, uri, errStr)
 			return nil, fmt.Errorf("API Error \n%s", errStr)
 		}
+		klog.Warningf("nicapi: API error for %s %s", method, uri)
 		return nil, fmt.Errorf("API error")
 	}
 
 	return r.Data, nil
 }
 
+// client wraps an *http.Client with NicAPI's retry/backoff policy, retrying
+// connection errors, HTTP 429, and HTTP 5xx with exponential backoff and
+// jitter, honoring a Retry-After header when the server sends one.
+type client struct {
+	httpClient  *http.Client
+	maxRetries  int
+	backoffBase time.Duration
+}
+
+func newClient(config *Config) *client {
+	return &client{
+		httpClient:  config.HTTPClient,
+		maxRetries:  config.MaxRetries,
+		backoffBase: config.BackoffBase,
+	}
+}
+
+// Do sends req, retrying on transient failures. The request body, if any,
+// is buffered up front so it can be replayed across attempts.
+func (cl *client) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= cl.maxRetries; attempt++ {
+		if reqBody != nil {
+			req.Body = io.NopCloser(bytes.NewReader(reqBody))
+		}
+
+		resp, err := cl.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			klog.V(4).Infof("nicapi: request error (attempt %d/%d): %v", attempt+1, cl.maxRetries+1, err)
+			cl.sleep(attempt, 0)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("nicapi: server returned %s", resp.Status)
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			klog.V(4).Infof("nicapi: retryable status %s (attempt %d/%d)", resp.Status, attempt+1, cl.maxRetries+1)
+			cl.sleep(attempt, retryAfter)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// sleep waits out a retry, honoring retryAfter when the server supplied one
+// and otherwise backing off exponentially with jitter. It is a no-op on the
+// last attempt.
+func (cl *client) sleep(attempt int, retryAfter time.Duration) {
+	if attempt >= cl.maxRetries {
+		return
+	}
+
+	if retryAfter > 0 {
+		time.Sleep(retryAfter)
+		return
+	}
+
+	delay := cl.backoffBase * time.Duration(1<<uint(attempt))
+	jitter := time.Duration(rand.Int63n(int64(delay) + 1))
+	time.Sleep(delay + jitter)
+}
+
+// parseRetryAfter parses the Retry-After header's delta-seconds form,
+// returning 0 if it is absent or not a plain integer.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
 // dnsRecord represents a DNS record
 type dnsRecord struct {
 	ID     int64  `json:"id,omitempty"`
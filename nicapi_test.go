@@ -3,12 +3,21 @@
 package nicapi
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jetstack/cert-manager/pkg/issuer/acme/dns/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 var (
@@ -50,7 +59,7 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restoreCloudFlareEnv()
 }
 
-func TestPresent(t *testing.T) {
+func TestLivePresent(t *testing.T) {
 	if !liveTest {
 		t.Skip("skipping live test")
 	}
@@ -62,7 +71,7 @@ func TestPresent(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestCleanUp(t *testing.T) {
+func TestLiveCleanUp(t *testing.T) {
 	if !liveTest {
 		t.Skip("skipping live test")
 	}
@@ -75,3 +84,518 @@ func TestCleanUp(t *testing.T) {
 	err = provider.CleanUp(domain, "_acme-challenge."+domain+".", "123d==")
 	assert.NoError(t, err)
 }
+
+// fakeNicAPI is a minimal in-memory stand-in for the NicAPI endpoints the
+// provider talks to, exposed over httptest.Server so the provider can be
+// exercised without a live account or real DNS.
+type fakeNicAPI struct {
+	mu             sync.Mutex
+	zone           string
+	nextID         int64
+	records        []dnsRecord
+	lastAuthHeader string
+}
+
+func newFakeNicAPI(zone string, records ...dnsRecord) *fakeNicAPI {
+	f := &fakeNicAPI{zone: zone, nextID: 1}
+	for _, rec := range records {
+		f.records = append(f.records, f.withID(rec))
+	}
+	return f
+}
+
+func (f *fakeNicAPI) withID(rec dnsRecord) dnsRecord {
+	rec.ID = f.nextID
+	f.nextID++
+	return rec
+}
+
+func (f *fakeNicAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/dns/zones/show", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.lastAuthHeader = r.Header.Get("Authorization")
+
+		var zone dnsZone
+		zone.Zone.Name = f.zone
+		zone.Zone.Records = f.records
+		writeAPISuccess(w, zone)
+	})
+
+	mux.HandleFunc("/dns/zones/records/add", func(w http.ResponseWriter, r *http.Request) {
+		var req dnsPostRecord
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			panic(err)
+		}
+
+		f.mu.Lock()
+		for _, rec := range req.Records {
+			f.records = append(f.records, f.withID(rec))
+		}
+		f.mu.Unlock()
+
+		writeAPISuccess(w, nil)
+	})
+
+	mux.HandleFunc("/dns/zones/records/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req dnsPostRecord
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			panic(err)
+		}
+
+		f.mu.Lock()
+		for _, del := range req.Records {
+			var kept []dnsRecord
+			for _, rec := range f.records {
+				if rec.ID == del.ID {
+					continue
+				}
+				kept = append(kept, rec)
+			}
+			f.records = kept
+		}
+		f.mu.Unlock()
+
+		writeAPISuccess(w, nil)
+	})
+
+	return mux
+}
+
+func writeAPISuccess(w http.ResponseWriter, data interface{}) {
+	writeAPIResponse(w, "success", data, nil)
+}
+
+func writeAPIResponse(w http.ResponseWriter, status string, data interface{}, errors []map[string]interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		body = []byte("null")
+	}
+
+	resp := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"clientTransactionId": "test",
+			"serverTransactionId": "test",
+		},
+		"messages": map[string]interface{}{
+			"errors":   errors,
+			"warnings": []interface{}{},
+			"success":  []interface{}{},
+		},
+		"status": status,
+		"data":   json.RawMessage(body),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(err)
+	}
+}
+
+// testConfig builds a Config pointed at server and short-circuits the
+// DNS-dependent parts of the provider (zone discovery, propagation
+// checking) so tests don't depend on real DNS.
+func testConfig(server *httptest.Server, zone string) *Config {
+	config := NewDefaultConfig()
+	config.AuthKey = "test-key"
+	config.APIURL = server.URL
+	config.HTTPClient = server.Client()
+	config.DisablePropagationCheck = true
+	config.BackoffBase = 0
+	config.FindZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return util.ToFqdn(zone), nil
+	}
+
+	return config
+}
+
+func TestGetHostedZone(t *testing.T) {
+	fake := newFakeNicAPI("example.com")
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	zone, err := provider.getHostedZone("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", zone.Zone.Name)
+	assert.Equal(t, "Bearer test-key", fake.lastAuthHeader)
+}
+
+func TestNewDNSProviderSecretRef(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nicapi-creds", Namespace: "cert-manager"},
+		Data:       map[string][]byte{"api-key": []byte("secret-value")},
+	})
+
+	provider, err := NewDNSProviderSecretRef(k8sClient, "cert-manager", corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "nicapi-creds"},
+		Key:                  "api-key",
+	}, util.RecursiveNameservers)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-value", provider.authKey)
+}
+
+func TestNewDNSProviderSecretRefMissingKey(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nicapi-creds", Namespace: "cert-manager"},
+		Data:       map[string][]byte{},
+	})
+
+	_, err := NewDNSProviderSecretRef(k8sClient, "cert-manager", corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "nicapi-creds"},
+		Key:                  "api-key",
+	}, util.RecursiveNameservers)
+	require.Error(t, err)
+}
+
+func TestPresentFirstTime(t *testing.T) {
+	fake := newFakeNicAPI("example.com")
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "_acme-challenge.example.com.", "value-1")
+	require.NoError(t, err)
+
+	assert.Len(t, fake.records, 1)
+	assert.Equal(t, "value-1", fake.records[0].Data)
+	assert.Equal(t, "TXT", fake.records[0].Type)
+}
+
+func TestPresentSameValueIsNoop(t *testing.T) {
+	fake := newFakeNicAPI("example.com", dnsRecord{Name: "_acme-challenge", Type: "TXT", Data: "value-1"})
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "_acme-challenge.example.com.", "value-1")
+	require.NoError(t, err)
+
+	assert.Len(t, fake.records, 1)
+}
+
+func TestPresentDifferentValueAppends(t *testing.T) {
+	// e.g. example.com and *.example.com solved concurrently: both target
+	// _acme-challenge.example.com but carry different values, so the
+	// existing record must be kept, not replaced.
+	fake := newFakeNicAPI("example.com", dnsRecord{Name: "_acme-challenge", Type: "TXT", Data: "value-1"})
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "_acme-challenge.example.com.", "value-2")
+	require.NoError(t, err)
+
+	require.Len(t, fake.records, 2)
+	assert.Equal(t, "value-1", fake.records[0].Data)
+	assert.Equal(t, "value-2", fake.records[1].Data)
+}
+
+func TestCleanUpPresent(t *testing.T) {
+	fake := newFakeNicAPI("example.com",
+		dnsRecord{Name: "_acme-challenge", Type: "TXT", Data: "value-1"},
+		dnsRecord{Name: "_acme-challenge", Type: "TXT", Data: "value-2"},
+	)
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "_acme-challenge.example.com.", "value-1")
+	require.NoError(t, err)
+
+	require.Len(t, fake.records, 1)
+	assert.Equal(t, "value-2", fake.records[0].Data)
+}
+
+func TestCleanUpAbsentIsNoop(t *testing.T) {
+	fake := newFakeNicAPI("example.com")
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "_acme-challenge.example.com.", "value-1")
+	require.NoError(t, err)
+}
+
+func TestMakeRequestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIResponse(w, "error", nil, []map[string]interface{}{
+			{"code": 401, "message": "invalid auth token"},
+		})
+	}))
+	defer server.Close()
+
+	provider, err := NewDNSProviderConfig(testConfig(server, "example.com"))
+	require.NoError(t, err)
+
+	_, err = provider.getHostedZone("_acme-challenge.example.com.")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid auth token")
+}
+
+func TestMakeRequestRetriesOnTransientFailures(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var zone dnsZone
+		zone.Zone.Name = "example.com"
+		writeAPISuccess(w, zone)
+	}))
+	defer server.Close()
+
+	config := testConfig(server, "example.com")
+	config.MaxRetries = 3
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, err = provider.getHostedZone("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+// fakeResolver is an injectable stand-in for the network-backed
+// Config.LookupCNAME/LookupTXT defaults, so FollowCNAME and the
+// propagation check can be exercised without real DNS.
+type fakeResolver struct {
+	mu sync.Mutex
+
+	// cnames maps "server|name" to the CNAME target fakeResolver.LookupCNAME
+	// should return; a missing entry means "not a CNAME".
+	cnames map[string]string
+
+	// txts maps "server|name" to the TXT values fakeResolver.LookupTXT
+	// should return.
+	txts map[string][]string
+
+	calledServers []string
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{cnames: map[string]string{}, txts: map[string][]string{}}
+}
+
+func (f *fakeResolver) key(server, name string) string {
+	return server + "|" + name
+}
+
+func (f *fakeResolver) LookupCNAME(server, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calledServers = append(f.calledServers, server)
+	return f.cnames[f.key(server, name)], nil
+}
+
+func (f *fakeResolver) LookupTXT(server, name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.txts[f.key(server, name)], nil
+}
+
+func testProvider(t *testing.T, configure func(*Config)) *DNSProvider {
+	config := NewDefaultConfig()
+	config.AuthKey = "test-key"
+	if configure != nil {
+		configure(config)
+	}
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	return provider
+}
+
+func TestResolveFQDNFollowsCNAME(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.cnames[resolver.key("ns1", "_acme-challenge.example.com")] = "_acme-challenge.example.com.delegated.net."
+
+	provider := testProvider(t, func(c *Config) {
+		c.DNS01Nameservers = []string{"ns1"}
+		c.FollowCNAME = true
+		c.LookupCNAME = resolver.LookupCNAME
+	})
+
+	fqdn, err := provider.resolveFQDN("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.example.com.delegated.net.", fqdn)
+}
+
+func TestResolveFQDNNoCNAMEReturnsOriginal(t *testing.T) {
+	resolver := newFakeResolver()
+
+	provider := testProvider(t, func(c *Config) {
+		c.DNS01Nameservers = []string{"ns1"}
+		c.FollowCNAME = true
+		c.LookupCNAME = resolver.LookupCNAME
+	})
+
+	fqdn, err := provider.resolveFQDN("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.example.com.", fqdn)
+}
+
+func TestResolveFQDNDisabledSkipsLookup(t *testing.T) {
+	resolver := newFakeResolver()
+
+	provider := testProvider(t, func(c *Config) {
+		c.DNS01Nameservers = []string{"ns1"}
+		c.LookupCNAME = resolver.LookupCNAME
+	})
+
+	fqdn, err := provider.resolveFQDN("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.example.com.", fqdn)
+	assert.Empty(t, resolver.calledServers)
+}
+
+func TestLookupCNAMEFallsBackToSystemResolverWhenNoNameservers(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.cnames[resolver.key("", "_acme-challenge.example.com")] = "delegated.example.net."
+
+	provider := testProvider(t, func(c *Config) {
+		c.FollowCNAME = true
+		c.LookupCNAME = resolver.LookupCNAME
+	})
+
+	fqdn, err := provider.resolveFQDN("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "delegated.example.net.", fqdn)
+	assert.Equal(t, []string{""}, resolver.calledServers)
+}
+
+func TestPollServersDoesNotGetStuckOnASlowServer(t *testing.T) {
+	// ns1 never picks up the record; ns2 already has it. A single-server
+	// exhaust-then-move-on loop would burn the whole deadline on ns1 and
+	// never see ns2's answer in time.
+	resolver := newFakeResolver()
+	resolver.txts[resolver.key("ns2", "_acme-challenge.example.com")] = []string{"the-value"}
+
+	provider := testProvider(t, func(c *Config) {
+		c.PropagationTimeout = 30 * time.Millisecond
+		c.PollingInterval = time.Millisecond
+		c.LookupTXT = func(server, name string) ([]string, error) {
+			if server == "ns1" {
+				return nil, nil
+			}
+			return resolver.LookupTXT(server, name)
+		}
+	})
+
+	err := provider.pollServers([]string{"ns1", "ns2"}, "_acme-challenge.example.com.", "the-value")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ns1")
+	assert.NotContains(t, err.Error(), "ns2")
+}
+
+func TestPollServersSucceedsOnceAllServersMatch(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.txts[resolver.key("ns1", "_acme-challenge.example.com")] = []string{"the-value"}
+	resolver.txts[resolver.key("ns2", "_acme-challenge.example.com")] = []string{"the-value"}
+
+	provider := testProvider(t, func(c *Config) {
+		c.PropagationTimeout = time.Second
+		c.PollingInterval = time.Millisecond
+		c.LookupTXT = resolver.LookupTXT
+	})
+
+	err := provider.pollServers([]string{"ns1", "ns2"}, "_acme-challenge.example.com.", "the-value")
+	require.NoError(t, err)
+}
+
+func TestPollServersWithNoServersFails(t *testing.T) {
+	// with no nameservers to check, nothing was actually verified: this
+	// must not be reported as successful propagation.
+	provider := testProvider(t, nil)
+
+	err := provider.pollServers(nil, "_acme-challenge.example.com.", "the-value")
+	require.Error(t, err)
+}
+
+func TestPresentWaitsForPropagation(t *testing.T) {
+	fake := newFakeNicAPI("example.com")
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	var polls int32
+
+	config := testConfig(server, "example.com")
+	config.DisablePropagationCheck = false
+	config.PropagationTimeout = time.Second
+	config.PollingInterval = time.Millisecond
+	config.LookupNS = func(zone string) ([]string, error) {
+		return []string{"ns1"}, nil
+	}
+	config.LookupTXT = func(server, name string) ([]string, error) {
+		if atomic.AddInt32(&polls, 1) < 3 {
+			// not propagated yet on the first couple of polls
+			return nil, nil
+		}
+		return []string{"value-1"}, nil
+	}
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "_acme-challenge.example.com.", "value-1")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&polls), int32(3))
+}
+
+func TestPresentPropagationTimesOut(t *testing.T) {
+	fake := newFakeNicAPI("example.com")
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	config := testConfig(server, "example.com")
+	config.DisablePropagationCheck = false
+	config.PropagationTimeout = 20 * time.Millisecond
+	config.PollingInterval = time.Millisecond
+	config.LookupNS = func(zone string) ([]string, error) {
+		return []string{"ns1"}, nil
+	}
+	config.LookupTXT = func(server, name string) ([]string, error) {
+		return nil, nil
+	}
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "_acme-challenge.example.com.", "value-1")
+	require.Error(t, err)
+}
+
+func TestMakeRequestGivesUpAfterMaxRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	config := testConfig(server, "example.com")
+	config.MaxRetries = 1
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, err = provider.getHostedZone("_acme-challenge.example.com.")
+	require.Error(t, err)
+}